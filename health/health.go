@@ -0,0 +1,283 @@
+// Package health tracks per-resolver success rate, latency, and error-class counts over a
+// sliding window, and uses them to steer resolver selection away from degraded or dead
+// resolvers. Resolvers whose error ratio exceeds errorThreshold are quarantined with
+// exponential backoff and occasionally re-probed to see if they've recovered.
+package health
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Outcome classifies how a single query against a resolver resolved.
+type Outcome int
+
+const (
+	Success Outcome = iota
+	Timeout
+	Refused
+	ServFail
+	OtherFailure
+)
+
+const (
+	// windowSize bounds the sliding window of recent outcomes kept per resolver.
+	windowSize = 200
+
+	// minSamples is how many outcomes a resolver needs before it's judged healthy/unhealthy;
+	// below this a resolver is always eligible so a fresh resolver gets a fair trial.
+	minSamples = 20
+
+	// errorThreshold is the error ratio over the window above which a resolver is quarantined.
+	errorThreshold = 0.5
+)
+
+// quarantineBackoff is the schedule of quarantine durations: 30s, 1m, 5m, then holding at 5m
+// for any resolver that keeps failing its probes.
+var quarantineBackoff = []time.Duration{
+	30 * time.Second,
+	1 * time.Minute,
+	5 * time.Minute,
+}
+
+// Entry is a point-in-time snapshot of one resolver's health.
+type Entry struct {
+	Server           string  `json:"server"`
+	Samples          int     `json:"samples"`
+	SuccessRate      float64 `json:"success_rate"`
+	MedianLatencyMs  float64 `json:"median_latency_ms"`
+	Timeouts         int     `json:"timeouts"`
+	Refused          int     `json:"refused"`
+	ServFail         int     `json:"servfail"`
+	Quarantined      bool    `json:"quarantined"`
+	QuarantinedUntil string  `json:"quarantined_until,omitempty"`
+}
+
+type sample struct {
+	outcome Outcome
+	latency time.Duration
+}
+
+// resolverStats is a fixed-capacity ring buffer of recent outcomes for one resolver, plus its
+// current quarantine state.
+type resolverStats struct {
+	mu               sync.Mutex
+	samples          []sample
+	next             int
+	quarantinedUntil time.Time
+	backoffIdx       int
+}
+
+func (s *resolverStats) record(outcome Outcome, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.samples) < windowSize {
+		s.samples = append(s.samples, sample{outcome, latency})
+	} else {
+		s.samples[s.next] = sample{outcome, latency}
+		s.next = (s.next + 1) % windowSize
+	}
+
+	if len(s.samples) < minSamples {
+		return
+	}
+
+	if s.errorRatioLocked() > errorThreshold {
+		idx := s.backoffIdx
+		if idx >= len(quarantineBackoff) {
+			idx = len(quarantineBackoff) - 1
+		}
+		s.quarantinedUntil = time.Now().Add(quarantineBackoff[idx])
+		if s.backoffIdx < len(quarantineBackoff)-1 {
+			s.backoffIdx++
+		}
+	} else if !s.quarantinedUntil.IsZero() {
+		s.quarantinedUntil = time.Time{}
+		s.backoffIdx = 0
+	}
+}
+
+func (s *resolverStats) errorRatioLocked() float64 {
+	if len(s.samples) == 0 {
+		return 0
+	}
+	failures := 0
+	for _, sm := range s.samples {
+		if sm.outcome != Success {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(s.samples))
+}
+
+func (s *resolverStats) successRateLocked() float64 {
+	if len(s.samples) == 0 {
+		return 1 // optimistic default so an unseen resolver gets a fair trial
+	}
+	return 1 - s.errorRatioLocked()
+}
+
+func (s *resolverStats) medianLatencyMsLocked() float64 {
+	latencies := make([]float64, 0, len(s.samples))
+	for _, sm := range s.samples {
+		if sm.outcome == Success {
+			latencies = append(latencies, float64(sm.latency.Milliseconds()))
+		}
+	}
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	sort.Float64s(latencies)
+	mid := len(latencies) / 2
+	if len(latencies)%2 == 0 {
+		return (latencies[mid-1] + latencies[mid]) / 2
+	}
+	return latencies[mid]
+}
+
+// Tracker tracks health stats for a set of resolvers and picks among them, weighted toward the
+// healthy and fast ones.
+type Tracker struct {
+	mu    sync.Mutex
+	stats map[string]*resolverStats
+}
+
+// NewTracker returns an empty Tracker.
+func NewTracker() *Tracker {
+	return &Tracker{stats: make(map[string]*resolverStats)}
+}
+
+func (t *Tracker) statsFor(server string) *resolverStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.stats[server]
+	if !ok {
+		s = &resolverStats{samples: make([]sample, 0, windowSize)}
+		t.stats[server] = s
+	}
+	return s
+}
+
+// Record logs the outcome of one query against server.
+func (t *Tracker) Record(server string, outcome Outcome, latency time.Duration) {
+	t.statsFor(server).record(outcome, latency)
+}
+
+// Pick selects one of servers via weighted random choice, biased toward a higher success rate
+// and lower median latency, skipping any resolver still under quarantine. If every candidate is
+// quarantined, the one whose quarantine expires soonest is returned as a probe.
+func (t *Tracker) Pick(servers []string) string {
+	if len(servers) == 0 {
+		return ""
+	}
+
+	type candidate struct {
+		server string
+		weight float64
+	}
+
+	now := time.Now()
+	var eligible []candidate
+	var soonest string
+	var soonestAt time.Time
+
+	for _, server := range servers {
+		s := t.statsFor(server)
+
+		s.mu.Lock()
+		quarantinedUntil := s.quarantinedUntil
+		weight := s.successRateLocked() / (1 + s.medianLatencyMsLocked()/50)
+		s.mu.Unlock()
+
+		if now.Before(quarantinedUntil) {
+			if soonest == "" || quarantinedUntil.Before(soonestAt) {
+				soonest, soonestAt = server, quarantinedUntil
+			}
+			continue
+		}
+
+		if weight <= 0 {
+			weight = 0.01
+		}
+		eligible = append(eligible, candidate{server, weight})
+	}
+
+	if len(eligible) == 0 {
+		return soonest
+	}
+
+	total := 0.0
+	for _, c := range eligible {
+		total += c.weight
+	}
+
+	r := rand.Float64() * total
+	for _, c := range eligible {
+		r -= c.weight
+		if r <= 0 {
+			return c.server
+		}
+	}
+
+	return eligible[len(eligible)-1].server
+}
+
+// Snapshot returns the current health entry for every resolver seen so far, sorted by server
+// name for stable output.
+func (t *Tracker) Snapshot() []Entry {
+	t.mu.Lock()
+	servers := make([]string, 0, len(t.stats))
+	for server := range t.stats {
+		servers = append(servers, server)
+	}
+	t.mu.Unlock()
+
+	sort.Strings(servers)
+
+	entries := make([]Entry, 0, len(servers))
+	for _, server := range servers {
+		s := t.statsFor(server)
+
+		s.mu.Lock()
+		entry := Entry{
+			Server:          server,
+			Samples:         len(s.samples),
+			SuccessRate:     s.successRateLocked(),
+			MedianLatencyMs: s.medianLatencyMsLocked(),
+			Quarantined:     time.Now().Before(s.quarantinedUntil),
+		}
+		for _, sm := range s.samples {
+			switch sm.outcome {
+			case Timeout:
+				entry.Timeouts++
+			case Refused:
+				entry.Refused++
+			case ServFail:
+				entry.ServFail++
+			}
+		}
+		if entry.Quarantined {
+			entry.QuarantinedUntil = s.quarantinedUntil.Format(time.RFC3339)
+		}
+		s.mu.Unlock()
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// ServeHTTP serves the current health snapshot as JSON, for use as an http.Handler.
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(t.Snapshot()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,144 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerQuarantinesAboveErrorThreshold(t *testing.T) {
+	tr := NewTracker()
+
+	// 20 samples (minSamples) at a 50% error ratio is not enough to exceed errorThreshold; one
+	// more failure tips it over.
+	for i := 0; i < 10; i++ {
+		tr.Record("r1", Success, time.Millisecond)
+		tr.Record("r1", Timeout, time.Millisecond)
+	}
+	if got := tr.Snapshot()[0]; got.Quarantined {
+		t.Fatalf("resolver quarantined at exactly the threshold: %+v", got)
+	}
+
+	tr.Record("r1", Timeout, time.Millisecond)
+
+	got := tr.Snapshot()[0]
+	if !got.Quarantined {
+		t.Fatalf("expected resolver to be quarantined once its error ratio exceeds %v, got %+v", errorThreshold, got)
+	}
+	if got.QuarantinedUntil == "" {
+		t.Fatal("expected QuarantinedUntil to be set while quarantined")
+	}
+}
+
+func TestTrackerBelowMinSamplesNeverQuarantines(t *testing.T) {
+	tr := NewTracker()
+
+	for i := 0; i < minSamples-1; i++ {
+		tr.Record("fresh", Timeout, time.Millisecond)
+	}
+
+	if got := tr.Snapshot()[0]; got.Quarantined {
+		t.Fatalf("a resolver below minSamples should always get a fair trial, got %+v", got)
+	}
+}
+
+func TestTrackerBackoffEscalatesThenHolds(t *testing.T) {
+	s := &resolverStats{samples: make([]sample, 0, windowSize)}
+
+	// record() re-escalates the backoff tier on every call made while the window is still over
+	// errorThreshold, so feed it one failure at a time and stop the instant quarantinedUntil
+	// actually moves - that's exactly one escalation, regardless of how many free samples
+	// minSamples absorbed first.
+	nextEscalation := func() time.Duration {
+		s.mu.Lock()
+		before := s.quarantinedUntil
+		s.mu.Unlock()
+
+		for {
+			s.record(Timeout, time.Millisecond)
+			s.mu.Lock()
+			after := s.quarantinedUntil
+			s.mu.Unlock()
+			if after != before {
+				return time.Until(after)
+			}
+		}
+	}
+
+	var gotBackoffs []time.Duration
+	for i := 0; i < len(quarantineBackoff)+1; i++ {
+		gotBackoffs = append(gotBackoffs, nextEscalation())
+	}
+
+	for i, want := range quarantineBackoff {
+		if d := gotBackoffs[i]; d < want-time.Second || d > want {
+			t.Errorf("backoff %d: got ~%v, want ~%v", i, d, want)
+		}
+	}
+	// Once past the schedule's end, it holds at the last (longest) tier instead of growing further.
+	last := quarantineBackoff[len(quarantineBackoff)-1]
+	if d := gotBackoffs[len(quarantineBackoff)]; d < last-time.Second || d > last {
+		t.Errorf("backoff past schedule end: got ~%v, want it to hold at ~%v", d, last)
+	}
+}
+
+func TestTrackerClearsQuarantineOnRecovery(t *testing.T) {
+	tr := NewTracker()
+
+	for i := 0; i < minSamples; i++ {
+		tr.Record("flaky", Timeout, time.Millisecond)
+	}
+	if got := tr.Snapshot()[0]; !got.Quarantined {
+		t.Fatalf("expected quarantine after a run of failures, got %+v", got)
+	}
+
+	for i := 0; i < windowSize; i++ {
+		tr.Record("flaky", Success, time.Millisecond)
+	}
+	if got := tr.Snapshot()[0]; got.Quarantined {
+		t.Fatalf("expected quarantine to clear once the error ratio drops, got %+v", got)
+	}
+}
+
+func TestPickSkipsQuarantinedResolvers(t *testing.T) {
+	tr := NewTracker()
+
+	for i := 0; i < minSamples; i++ {
+		tr.Record("bad", Timeout, time.Millisecond)
+		tr.Record("good", Success, time.Millisecond)
+	}
+
+	for i := 0; i < 20; i++ {
+		if got := tr.Pick([]string{"bad", "good"}); got != "good" {
+			t.Fatalf("Pick chose quarantined resolver %q over healthy one", got)
+		}
+	}
+}
+
+func TestPickReturnsSoonestExpiringWhenAllQuarantined(t *testing.T) {
+	tr := NewTracker()
+
+	for _, server := range []string{"sooner-recovery", "later-recovery"} {
+		for i := 0; i < minSamples; i++ {
+			tr.Record(server, Timeout, time.Millisecond)
+		}
+	}
+
+	// Both are quarantined on the same backoff tier; force distinct expiries directly rather
+	// than via timing, since both quarantinedUntil stamps would otherwise land within the same
+	// tick.
+	now := time.Now()
+	soonerStats := tr.statsFor("sooner-recovery")
+	soonerStats.mu.Lock()
+	soonerStats.quarantinedUntil = now.Add(time.Second)
+	soonerStats.mu.Unlock()
+
+	laterStats := tr.statsFor("later-recovery")
+	laterStats.mu.Lock()
+	laterStats.quarantinedUntil = now.Add(time.Hour)
+	laterStats.mu.Unlock()
+
+	got := tr.Pick([]string{"sooner-recovery", "later-recovery"})
+	if got != "sooner-recovery" {
+		t.Fatalf("Pick = %q, want the resolver whose quarantine expires soonest", got)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// commonLowHosts are the low-order host values most frequently seen assigned to infrastructure
+// and CPE devices, mirroring the "::1", "::2", ... convention carried over from IPv4 gateways.
+var commonLowHosts = []uint16{
+	0x1, 0x2, 0x3, 0x4, 0x5, 0xa, 0xf, 0x10, 0x64, 0xfe, 0xff, 0x100, 0x1000, 0xdead, 0xbeef, 0xffff,
+}
+
+// commonOUIs are vendor MAC prefixes commonly seen on infrastructure and virtualization hosts,
+// used as the basis for EUI-64 derived host identifiers.
+var commonOUIs = [][3]byte{
+	{0x00, 0x1a, 0x2b},
+	{0x00, 0x50, 0x56}, // VMware
+	{0x08, 0x00, 0x27}, // VirtualBox
+	{0x00, 0x0c, 0x29}, // VMware
+	{0x52, 0x54, 0x00}, // QEMU/KVM
+}
+
+// slaacWeightedNibbles biases random SLAAC tail generation toward the hex digits that show up
+// disproportionately often in real-world privacy-extension and randomized interface identifiers.
+var slaacWeightedNibbles = []byte{
+	0x0, 0x0, 0x0, 0x1, 0x1, 0xa, 0xe, 0xf,
+	0x2, 0x3, 0x4, 0x5, 0x6, 0x7, 0x8, 0x9, 0xb, 0xc, 0xd,
+}
+
+// commonIPv4Tails are IPv4 addresses frequently embedded as the low 32 bits of an IPv4-mapped
+// IPv6 host (::a.b.c.d), matching patterns seen in real reverse DNS sweeps.
+var commonIPv4Tails = []string{
+	"0.0.0.1", "1.1.1.1", "8.8.8.8", "10.0.0.1", "192.168.1.1", "127.0.0.1",
+}
+
+// ipv6GenOpts tunes how many SLAAC-style candidates ipv6Stream emits per network.
+type ipv6GenOpts struct {
+	slaacPerNet int
+}
+
+var defaultIPv6GenOpts = ipv6GenOpts{slaacPerNet: 256}
+
+// estimateIPv6Candidates returns the number of candidates ipv6Stream emits for the given CIDRs
+// when every prefix leaves at least 64 host bits; for longer prefixes, tails that don't fit are
+// skipped, so the real count can be lower. It sizes the progress bar and bounds totalShards.
+func estimateIPv6Candidates(cidrs []string, opts ipv6GenOpts) uint64 {
+	perNet := uint64(len(commonLowHosts) + len(commonOUIs) + len(commonIPv4Tails) + opts.slaacPerNet)
+	return uint64(len(cidrs)) * perNet
+}
+
+// ipv6Stream enumerates "smart" IPv6 PTR sweep candidates across the given CIDRs. Brute-forcing
+// a /64, let alone a /32, is infeasible, so instead of walking the address space linearly it
+// emits addresses matching patterns commonly seen in reverse DNS: low-nibble hosts, EUI-64
+// derived hosts, SLAAC-style addresses with weighted random nibbles, and IPv4-mapped tails.
+//
+// Sharding is applied to the flattened candidate stream (every totalShards-th candidate overall),
+// not to the CIDR list, so a sweep over one or a few CIDRs still splits work evenly across shards
+// instead of handing every shard but one an empty stream.
+func ipv6Stream(cidrs []string, shardNum, totalShards int, seed int64, opts ipv6GenOpts) (<-chan string, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, ipnet, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IPv6 CIDR %q: %v", c, err)
+		}
+		if ipnet.IP.To4() != nil {
+			return nil, fmt.Errorf("%q is not an IPv6 CIDR", c)
+		}
+		nets = append(nets, ipnet)
+	}
+	if len(nets) == 0 {
+		return nil, fmt.Errorf("no IPv6 CIDRs supplied")
+	}
+
+	if totalShards > 1 {
+		if total := estimateIPv6Candidates(cidrs, opts); uint64(totalShards) > total {
+			return nil, fmt.Errorf("totalShards (%d) exceeds the %d candidates these CIDRs would generate", totalShards, total)
+		}
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	out := make(chan string, 1024)
+
+	go func() {
+		defer close(out)
+
+		var n uint64
+		emit := func(ip net.IP) {
+			defer func() { n++ }()
+			if totalShards > 1 && n%uint64(totalShards) != uint64(shardNum-1) {
+				return
+			}
+			out <- ip.String()
+		}
+
+		for _, ipnet := range nets {
+			emitLowNibbleHosts(ipnet, emit)
+			emitEUI64Hosts(ipnet, emit)
+			emitSLAACHosts(ipnet, rng, opts.slaacPerNet, emit)
+			emitIPv4MappedTails(ipnet, emit)
+		}
+	}()
+
+	return out, nil
+}
+
+// withTail returns ipnet's network address with its low len(tail) bytes replaced by tail,
+// provided the network's prefix leaves at least that many host bits free. Returns nil otherwise.
+func withTail(ipnet *net.IPNet, tail []byte) net.IP {
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones < len(tail)*8 {
+		return nil
+	}
+
+	base := make(net.IP, net.IPv6len)
+	copy(base, ipnet.IP.To16())
+	copy(base[net.IPv6len-len(tail):], tail)
+
+	return base
+}
+
+func emitLowNibbleHosts(ipnet *net.IPNet, emit func(net.IP)) {
+	for _, h := range commonLowHosts {
+		tail := []byte{byte(h >> 8), byte(h)}
+		if ip := withTail(ipnet, tail); ip != nil {
+			emit(ip)
+		}
+	}
+}
+
+func emitEUI64Hosts(ipnet *net.IPNet, emit func(net.IP)) {
+	for _, oui := range commonOUIs {
+		// Flip the universal/local bit and splice in the ff:fe EUI-64 marker.
+		tail := []byte{
+			oui[0] ^ 0x02, oui[1], oui[2],
+			0xff, 0xfe,
+			0x01, 0x02, 0x03,
+		}
+		if ip := withTail(ipnet, tail); ip != nil {
+			emit(ip)
+		}
+	}
+}
+
+func emitSLAACHosts(ipnet *net.IPNet, rng *rand.Rand, n int, emit func(net.IP)) {
+	for i := 0; i < n; i++ {
+		tail := make([]byte, 8)
+		for b := range tail {
+			hi := slaacWeightedNibbles[rng.Intn(len(slaacWeightedNibbles))]
+			lo := slaacWeightedNibbles[rng.Intn(len(slaacWeightedNibbles))]
+			tail[b] = hi<<4 | lo
+		}
+		if ip := withTail(ipnet, tail); ip != nil {
+			emit(ip)
+		}
+	}
+}
+
+func emitIPv4MappedTails(ipnet *net.IPNet, emit func(net.IP)) {
+	for _, v4 := range commonIPv4Tails {
+		v4ip := net.ParseIP(v4).To4()
+		if v4ip == nil {
+			continue
+		}
+		if ip := withTail(ipnet, v4ip); ip != nil {
+			emit(ip)
+		}
+	}
+}
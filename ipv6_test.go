@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func drain(t *testing.T, ch <-chan string) []string {
+	t.Helper()
+	var got []string
+	for ip := range ch {
+		got = append(got, ip)
+	}
+	return got
+}
+
+func TestIPv6StreamShardsEvenlyAcrossASingleCIDR(t *testing.T) {
+	opts := ipv6GenOpts{slaacPerNet: 32}
+	total := estimateIPv6Candidates([]string{"2001:db8::/32"}, opts)
+
+	const shards = 4
+	var combined []string
+	for shard := 1; shard <= shards; shard++ {
+		out, err := ipv6Stream([]string{"2001:db8::/32"}, shard, shards, 1, opts)
+		if err != nil {
+			t.Fatalf("shard %d: %v", shard, err)
+		}
+		got := drain(t, out)
+		if len(got) == 0 {
+			t.Fatalf("shard %d of %d got zero candidates from a single CIDR (total=%d)", shard, shards, total)
+		}
+		combined = append(combined, got...)
+	}
+
+	if uint64(len(combined)) != total {
+		t.Fatalf("shards emitted %d candidates combined, want %d", len(combined), total)
+	}
+}
+
+func TestIPv6StreamRejectsTooManyShards(t *testing.T) {
+	opts := ipv6GenOpts{slaacPerNet: 1}
+	total := estimateIPv6Candidates([]string{"2001:db8::/64"}, opts)
+
+	_, err := ipv6Stream([]string{"2001:db8::/64"}, 1, int(total)+1, 1, opts)
+	if err == nil {
+		t.Fatal("expected an error when totalShards exceeds the candidate count, got nil")
+	}
+}
+
+func TestIPv6StreamSeedZeroIsNotDeterministic(t *testing.T) {
+	opts := ipv6GenOpts{slaacPerNet: 64}
+
+	out1, err := ipv6Stream([]string{"2001:db8::/32"}, 1, 1, 0, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first := drain(t, out1)
+
+	out2, err := ipv6Stream([]string{"2001:db8::/32"}, 1, 1, 0, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second := drain(t, out2)
+
+	identical := len(first) == len(second)
+	if identical {
+		for i := range first {
+			if first[i] != second[i] {
+				identical = false
+				break
+			}
+		}
+	}
+	if identical {
+		t.Fatal("seed 0 produced byte-identical candidates across two runs; it should reseed from the current time")
+	}
+}
+
+func TestIPv6StreamSeedNonZeroIsDeterministic(t *testing.T) {
+	opts := ipv6GenOpts{slaacPerNet: 64}
+
+	out1, err := ipv6Stream([]string{"2001:db8::/32"}, 1, 1, 42, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first := drain(t, out1)
+
+	out2, err := ipv6Stream([]string{"2001:db8::/32"}, 1, 1, 42, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second := drain(t, out2)
+
+	if len(first) != len(second) {
+		t.Fatalf("got %d and %d candidates for the same seed, want equal counts", len(first), len(second))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("candidate %d differs between runs for the same seed: %q vs %q", i, first[i], second[i])
+		}
+	}
+}
+
+func TestIPv6StreamRejectsIPv4CIDR(t *testing.T) {
+	if _, err := ipv6Stream([]string{"192.0.2.0/24"}, 1, 1, 1, defaultIPv6GenOpts); err == nil {
+		t.Fatal("expected an error for an IPv4 CIDR, got nil")
+	}
+}
+
+func TestWithTailRespectsHostBits(t *testing.T) {
+	_, ipnet, err := net.ParseCIDR("2001:db8::/120")
+	if err != nil {
+		t.Fatalf("ParseCIDR: %v", err)
+	}
+
+	if ip := withTail(ipnet, make([]byte, 1)); ip == nil {
+		t.Fatal("expected a 1-byte tail to fit in a /120's 8 host bits")
+	}
+	if ip := withTail(ipnet, make([]byte, 2)); ip != nil {
+		t.Fatalf("expected a 2-byte tail not to fit in a /120's 8 host bits, got %v", ip)
+	}
+}
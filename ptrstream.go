@@ -2,38 +2,55 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/acidvegas/golcg"
+	"github.com/acidvegas/ptrstream/health"
+	"github.com/acidvegas/ptrstream/querylog"
+	"github.com/acidvegas/ptrstream/resolver"
 	"github.com/miekg/dns"
 	"github.com/rivo/tview"
 )
 
 const defaultResolversURL = "https://raw.githubusercontent.com/trickest/resolvers/refs/heads/main/resolvers.txt"
 
+// queryLogTopCap bounds the cardinality tracked per top-N category (domains, TLDs, CNAME
+// targets, failing resolvers) so memory stays flat under a sweep touching millions of suffixes.
+const queryLogTopCap = 2000
+
+// defaultMaxCNAMEDepth bounds how many CNAME hops lookupWithRetry will follow looking for a
+// terminal PTR/A/AAAA record before giving up.
+const defaultMaxCNAMEDepth = 4
+
 type Config struct {
 	concurrency   int
 	timeout       time.Duration
 	retries       int
 	dnsServers    []string
-	serverIndex   int
+	specs         map[string]resolver.Spec
 	debug         bool
-	outputFile    *os.File
+	queryLog      *querylog.Aggregator
+	health        *health.Tracker
+	maxCNAMEDepth int
 	mu            sync.Mutex
 	lastDNSUpdate time.Time
 	updateMu      sync.Mutex
 	loop          bool
+	resolvers     map[string]resolver.Resolver
+	resolverMu    sync.Mutex
 }
 
 type Stats struct {
@@ -65,21 +82,87 @@ func (s *Stats) incrementCNAME() {
 	atomic.AddUint64(&s.cnames, 1)
 }
 
-func (c *Config) getNextServer() string {
+// pickServer selects the next resolver to query, weighted toward resolvers with a better
+// success rate and lower latency and skipping any currently quarantined for excessive errors.
+func (c *Config) pickServer() string {
 	if err := c.updateDNSServers(); err != nil {
 		fmt.Printf("Failed to update DNS servers: %v\n", err)
 	}
 
+	c.mu.Lock()
+	servers := make([]string, len(c.dnsServers))
+	copy(servers, c.dnsServers)
+	c.mu.Unlock()
+
+	return c.health.Pick(servers)
+}
+
+func (c *Config) specFor(server string) (resolver.Spec, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	spec, ok := c.specs[server]
+	return spec, ok
+}
+
+// exchange sends m to server using its configured transport (plain UDP/TCP, DoT, or DoH).
+func (c *Config) exchange(server string, m *dns.Msg) (*dns.Msg, bool, error) {
+	spec, ok := c.specFor(server)
+	if !ok {
+		return nil, false, fmt.Errorf("unknown resolver %q", server)
+	}
+	return c.exchangeSpec(server, spec, m)
+}
 
-	if len(c.dnsServers) == 0 {
-		return ""
+// exchangeTCP retries m over TCP against the same server, used when a UDP reply is truncated.
+func (c *Config) exchangeTCP(server string, m *dns.Msg) (*dns.Msg, bool, error) {
+	spec, ok := c.specFor(server)
+	if !ok {
+		return nil, false, fmt.Errorf("unknown resolver %q", server)
 	}
+	spec.Scheme = "tcp"
+	return c.exchangeSpec(server+"#tcp", spec, m)
+}
 
-	server := c.dnsServers[c.serverIndex]
-	c.serverIndex = (c.serverIndex + 1) % len(c.dnsServers)
-	return server
+// evictQuarantined closes and evicts the cached resolver connection for any server currently
+// quarantined by health scoring, so a degraded persistent connection isn't kept alive only to be
+// reused once traffic has already been routed away from it. The connection is re-dialed lazily
+// the next time the resolver is picked again after its quarantine expires.
+func (c *Config) evictQuarantined() {
+	for _, e := range c.health.Snapshot() {
+		if !e.Quarantined {
+			continue
+		}
+
+		c.resolverMu.Lock()
+		for _, key := range []string{e.Server, e.Server + "#tcp"} {
+			r, ok := c.resolvers[key]
+			if !ok {
+				continue
+			}
+			if closer, ok := r.(io.Closer); ok {
+				closer.Close()
+			}
+			delete(c.resolvers, key)
+		}
+		c.resolverMu.Unlock()
+	}
+}
+
+func (c *Config) exchangeSpec(cacheKey string, spec resolver.Spec, m *dns.Msg) (*dns.Msg, bool, error) {
+	c.resolverMu.Lock()
+	r, ok := c.resolvers[cacheKey]
+	if !ok {
+		var err error
+		r, err = resolver.New(spec, c.timeout)
+		if err != nil {
+			c.resolverMu.Unlock()
+			return nil, false, err
+		}
+		c.resolvers[cacheKey] = r
+	}
+	c.resolverMu.Unlock()
+
+	return r.Exchange(m)
 }
 
 func fetchDefaultResolvers() ([]string, error) {
@@ -149,13 +232,117 @@ type DNSResponse struct {
 	RecordType string // "PTR" or "CNAME"
 	Target     string // For CNAME records, stores the target
 	TTL        uint32 // Add TTL field
+	Chain      []querylog.ChainHop
+}
+
+// isArpaName reports whether name is a reverse-DNS name (in-addr.arpa or ip6.arpa), as opposed
+// to an ordinary hostname that a CNAME chain might otherwise terminate at.
+func isArpaName(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	return strings.HasSuffix(name, ".in-addr.arpa") || strings.HasSuffix(name, ".ip6.arpa")
+}
+
+// followChain resolves target recursively, querying a PTR record at each hop (or A/AAAA once
+// the chain leaves in-addr.arpa/ip6.arpa space) until it finds a terminal record, hits a loop,
+// or exceeds maxDepth. visited must already contain every name seen so far, including the
+// original query, so a CNAME chain that loops back on itself is detected rather than followed
+// forever.
+func followChain(cfg *Config, server, target string, visited map[string]bool, maxDepth int) ([]querylog.ChainHop, string, uint32, error) {
+	var chain []querylog.ChainHop
+
+	for depth := 0; depth < maxDepth; depth++ {
+		name := strings.TrimSuffix(target, ".")
+		key := strings.ToLower(name)
+		if visited[key] {
+			return chain, "", 0, fmt.Errorf("CNAME chain loop detected at %s", name)
+		}
+		visited[key] = true
+
+		qtypes := []uint16{dns.TypePTR}
+		if !isArpaName(name) {
+			qtypes = []uint16{dns.TypeA, dns.TypeAAAA}
+		}
+
+		var r *dns.Msg
+		for _, qtype := range qtypes {
+			m := new(dns.Msg)
+			m.SetQuestion(dns.Fqdn(name), qtype)
+			m.RecursionDesired = true
+
+			resp, truncated, err := cfg.exchange(server, m)
+			if err == nil && truncated {
+				if tr, _, terr := cfg.exchangeTCP(server, m); terr == nil {
+					resp = tr
+				}
+			}
+			if err != nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) == 0 {
+				continue
+			}
+			r = resp
+			break
+		}
+
+		if r == nil {
+			return chain, "", 0, fmt.Errorf("no further records found for %s", name)
+		}
+
+		var nextCNAME string
+		var cnameTTL uint32
+		for _, ans := range r.Answer {
+			switch rr := ans.(type) {
+			case *dns.PTR:
+				hopTarget := strings.TrimSuffix(rr.Ptr, ".")
+				chain = append(chain, querylog.ChainHop{Name: name, Type: "PTR", Target: hopTarget, TTL: rr.Hdr.Ttl})
+				return chain, hopTarget, rr.Hdr.Ttl, nil
+			case *dns.A:
+				chain = append(chain, querylog.ChainHop{Name: name, Type: "A", Target: rr.A.String(), TTL: rr.Hdr.Ttl})
+				return chain, rr.A.String(), rr.Hdr.Ttl, nil
+			case *dns.AAAA:
+				chain = append(chain, querylog.ChainHop{Name: name, Type: "AAAA", Target: rr.AAAA.String(), TTL: rr.Hdr.Ttl})
+				return chain, rr.AAAA.String(), rr.Hdr.Ttl, nil
+			case *dns.CNAME:
+				nextCNAME = strings.TrimSuffix(rr.Target, ".")
+				cnameTTL = rr.Hdr.Ttl
+			}
+		}
+
+		if nextCNAME == "" {
+			return chain, "", 0, fmt.Errorf("no further records found for %s", name)
+		}
+
+		chain = append(chain, querylog.ChainHop{Name: name, Type: "CNAME", Target: nextCNAME, TTL: cnameTTL})
+		target = nextCNAME
+	}
+
+	return chain, "", 0, fmt.Errorf("CNAME chain exceeded max depth of %d", maxDepth)
+}
+
+// classifyOutcome maps a query result to the health.Outcome used for resolver scheduling. Only
+// transport/server-level failures count against a resolver's health - a successful response
+// with no matching record (caught separately in lookupWithRetry) is not the resolver's fault.
+func classifyOutcome(r *dns.Msg, err error) health.Outcome {
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return health.Timeout
+		}
+		return health.OtherFailure
+	}
+
+	switch r.Rcode {
+	case dns.RcodeRefused:
+		return health.Refused
+	case dns.RcodeServerFailure:
+		return health.ServFail
+	default:
+		return health.Success
+	}
 }
 
 func lookupWithRetry(ip string, cfg *Config) (DNSResponse, error) {
 	var lastErr error
 
 	for i := 0; i < cfg.retries; i++ {
-		server := cfg.getNextServer()
+		server := cfg.pickServer()
 		if server == "" {
 			return DNSResponse{}, fmt.Errorf("no DNS servers available")
 		}
@@ -169,12 +356,16 @@ func lookupWithRetry(ip string, cfg *Config) (DNSResponse, error) {
 		m.SetQuestion(arpa, dns.TypePTR)
 		m.RecursionDesired = true
 
-		// Create DNS client
-		c := new(dns.Client)
-		c.Timeout = cfg.timeout
+		// Make the query, retrying over TCP if the UDP reply was truncated
+		start := time.Now()
+		r, truncated, err := cfg.exchange(server, m)
+		if err == nil && truncated {
+			if tr, _, terr := cfg.exchangeTCP(server, m); terr == nil {
+				r = tr
+			}
+		}
+		cfg.health.Record(server, classifyOutcome(r, err), time.Since(start))
 
-		// Make the query
-		r, _, err := c.Exchange(m, server)
 		if err != nil {
 			lastErr = err
 			continue
@@ -186,45 +377,67 @@ func lookupWithRetry(ip string, cfg *Config) (DNSResponse, error) {
 		}
 
 		logServer := server
-		if idx := strings.Index(server, ":"); idx != -1 {
-			logServer = server[:idx]
+		if spec, ok := cfg.specFor(server); ok {
+			logServer = spec.Host
 		}
 
 		// Process the response
 		if len(r.Answer) > 0 {
-			var names []string
-			var ttl uint32
-			var isCNAME bool
-			var target string
+			var ptrNames []string
+			var ptrTTL uint32
+			var hasCNAME bool
+			var cnameTarget string
+			var cnameTTL uint32
 
 			for _, ans := range r.Answer {
 				switch rr := ans.(type) {
 				case *dns.PTR:
-					names = append(names, rr.Ptr)
-					ttl = rr.Hdr.Ttl
+					ptrNames = append(ptrNames, strings.TrimSuffix(rr.Ptr, "."))
+					ptrTTL = rr.Hdr.Ttl
 				case *dns.CNAME:
-					isCNAME = true
-					names = append(names, rr.Hdr.Name)
-					target = rr.Target
-					ttl = rr.Hdr.Ttl
+					hasCNAME = true
+					cnameTarget = strings.TrimSuffix(rr.Target, ".")
+					cnameTTL = rr.Hdr.Ttl
 				}
 			}
 
-			if len(names) > 0 {
-				if isCNAME {
+			if len(ptrNames) > 0 {
+				return DNSResponse{
+					Names:      ptrNames,
+					Server:     logServer,
+					RecordType: "PTR",
+					TTL:        ptrTTL,
+				}, nil
+			}
+
+			if hasCNAME {
+				queriedName := strings.TrimSuffix(arpa, ".")
+				visited := map[string]bool{strings.ToLower(queriedName): true}
+				chain := []querylog.ChainHop{{Name: queriedName, Type: "CNAME", Target: cnameTarget, TTL: cnameTTL}}
+
+				hops, final, finalTTL, ferr := followChain(cfg, server, cnameTarget, visited, cfg.maxCNAMEDepth)
+				chain = append(chain, hops...)
+
+				if ferr == nil && final != "" {
 					return DNSResponse{
-						Names:      names,
+						Names:      []string{final},
 						Server:     logServer,
 						RecordType: "CNAME",
-						Target:     strings.TrimSuffix(target, "."),
-						TTL:        ttl,
+						Target:     final,
+						TTL:        finalTTL,
+						Chain:      chain,
 					}, nil
 				}
+
+				// The chain didn't fully resolve (loop, max depth, or a hop failed) - fall back
+				// to the first CNAME hop so the sweep still records what it learned.
 				return DNSResponse{
-					Names:      names,
+					Names:      []string{queriedName},
 					Server:     logServer,
-					RecordType: "PTR",
-					TTL:        ttl,
+					RecordType: "CNAME",
+					Target:     cnameTarget,
+					TTL:        cnameTTL,
+					Chain:      chain,
 				}, nil
 			}
 		}
@@ -251,23 +464,12 @@ func colorizeIPInPtr(ptr, ip string) string {
 		}
 	}
 
-	octets := strings.Split(ip, ".")
-
-	patterns := []string{
-		strings.ReplaceAll(ip, ".", "\\."),
-		strings.Join(reverse(strings.Split(ip, ".")), "\\."),
-		strings.ReplaceAll(ip, ".", "-"),
-		strings.Join(reverse(strings.Split(ip, ".")), "-"),
-	}
-
-	zeroPadded := make([]string, 4)
-	for i, octet := range octets {
-		zeroPadded[i] = fmt.Sprintf("%03d", parseInt(octet))
+	var patterns []string
+	if strings.Contains(ip, ":") {
+		patterns = ipv6Patterns(ip)
+	} else {
+		patterns = ipv4Patterns(ip)
 	}
-	patterns = append(patterns,
-		strings.Join(zeroPadded, "-"),
-		strings.Join(reverse(zeroPadded), "-"),
-	)
 
 	pattern := strings.Join(patterns, "|")
 	re := regexp.MustCompile("(" + pattern + ")")
@@ -300,6 +502,9 @@ func colorizeIPInPtr(ptr, ip string) string {
 	if strings.HasSuffix(finalResult, ".in-addr.arpa") {
 		finalResult = finalResult[:len(finalResult)-13] + ".[blue]in-addr.arpa"
 	}
+	if strings.HasSuffix(finalResult, ".ip6.arpa") {
+		finalResult = finalResult[:len(finalResult)-9] + ".[blue]ip6.arpa"
+	}
 	if strings.HasSuffix(finalResult, ".gov") {
 		finalResult = finalResult[:len(finalResult)-4] + ".[red]gov"
 	}
@@ -316,8 +521,75 @@ func parseInt(s string) int {
 	return num
 }
 
+// ipv4Patterns builds the set of regexes used to spot an IPv4 address embedded in a PTR name,
+// covering the dotted, reversed-dotted, dashed, and zero-padded-dashed conventions.
+func ipv4Patterns(ip string) []string {
+	octets := strings.Split(ip, ".")
+
+	patterns := []string{
+		strings.ReplaceAll(ip, ".", "\\."),
+		strings.Join(reverse(octets), "\\."),
+		strings.ReplaceAll(ip, ".", "-"),
+		strings.Join(reverse(octets), "-"),
+	}
+
+	zeroPadded := make([]string, 4)
+	for i, octet := range octets {
+		zeroPadded[i] = fmt.Sprintf("%03d", parseInt(octet))
+	}
+	patterns = append(patterns,
+		strings.Join(zeroPadded, "-"),
+		strings.Join(reverse(zeroPadded), "-"),
+	)
+
+	return patterns
+}
+
+// ipv6Patterns builds the set of regexes used to spot an IPv6 address embedded in a PTR name,
+// covering the colon, compressed-hex, dashed, and nibble-reversed (ip6.arpa-style) conventions.
+func ipv6Patterns(ip string) []string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return []string{regexp.QuoteMeta(ip)}
+	}
+	full := parsed.To16()
+
+	nibbles := make([]string, 32)
+	for i, b := range full {
+		nibbles[2*i] = fmt.Sprintf("%x", b>>4)
+		nibbles[2*i+1] = fmt.Sprintf("%x", b&0xf)
+	}
+
+	lower := strings.ToLower(ip)
+
+	return []string{
+		strings.Join(nibbles, "\\."),
+		strings.Join(reverse(nibbles), "\\."),
+		regexp.QuoteMeta(lower),
+		regexp.QuoteMeta(strings.ReplaceAll(lower, ":", "")),
+		regexp.QuoteMeta(strings.ReplaceAll(lower, ":", "-")),
+	}
+}
+
 const maxBufferLines = 1000
 
+// ipv4ColWidth and ipv6ColWidth size the IP column in the TUI and debug output. IPv6 addresses
+// can run up to 39 characters (e.g. "2001:0db8:0000:0000:0000:0000:0000:0001"), far wider than
+// the dotted-quad IPv4 case, so the column width is picked per-address rather than hardcoded.
+const (
+	ipv4ColWidth = 15
+	ipv6ColWidth = 39
+)
+
+// formatIPColumn right-aligns ip to the column width appropriate for its family.
+func formatIPColumn(ip string) string {
+	width := ipv4ColWidth
+	if strings.Contains(ip, ":") {
+		width = ipv6ColWidth
+	}
+	return fmt.Sprintf("%*s", width, ip)
+}
+
 func worker(jobs <-chan string, wg *sync.WaitGroup, cfg *Config, stats *Stats, textView *tview.TextView, app *tview.Application) {
 	defer wg.Done()
 	for ip := range jobs {
@@ -338,15 +610,16 @@ func worker(jobs <-chan string, wg *sync.WaitGroup, cfg *Config, stats *Stats, t
 
 		if err != nil {
 			stats.incrementFailed()
+			logQueryFailure(cfg, timestamp, ip, response.Server)
 			if cfg.debug {
 				timestamp := time.Now().Format("2006-01-02 15:04:05")
 				errMsg := err.Error()
 				if idx := strings.LastIndex(errMsg, ": "); idx != -1 {
 					errMsg = errMsg[idx+2:]
 				}
-				debugLine := fmt.Sprintf("[gray]%s[-] [purple]%15s[-] [gray]│[-] [red]%s[-]\n",
+				debugLine := fmt.Sprintf("[gray]%s[-] [purple]%s[-] [gray]│[-] [red]%s[-]\n",
 					timestamp,
-					ip,
+					formatIPColumn(ip),
 					errMsg)
 				app.QueueUpdateDraw(func() {
 					fmt.Fprint(textView, debugLine)
@@ -360,9 +633,9 @@ func worker(jobs <-chan string, wg *sync.WaitGroup, cfg *Config, stats *Stats, t
 			stats.incrementFailed()
 			if cfg.debug {
 				timestamp := time.Now().Format("2006-01-02 15:04:05")
-				debugLine := fmt.Sprintf("[gray]%s[-] [purple]%15s[-] [gray]│[-] [red]No PTR record[-]\n",
+				debugLine := fmt.Sprintf("[gray]%s[-] [purple]%s[-] [gray]│[-] [red]No PTR record[-]\n",
 					timestamp,
-					ip)
+					formatIPColumn(ip))
 				app.QueueUpdateDraw(func() {
 					fmt.Fprint(textView, debugLine)
 					textView.ScrollToEnd()
@@ -385,29 +658,33 @@ func worker(jobs <-chan string, wg *sync.WaitGroup, cfg *Config, stats *Stats, t
 			continue
 		}
 
-		writeNDJSON(cfg, timestamp, ip, response.Server, ptr, response.RecordType, response.Target, response.TTL)
+		logQuery(cfg, timestamp, ip, response.Server, ptr, response.RecordType, response.Target, response.TTL, response.Chain)
 
 		timeStr := time.Now().Format("2006-01-02 15:04:05")
 		recordTypeColor := "[blue] PTR [-]"
 		if response.RecordType == "CNAME" {
 			stats.incrementCNAME()
 			recordTypeColor = "[fuchsia]CNAME[-]"
-			ptr = fmt.Sprintf("%s -> %s", ptr, response.Target)
+			if len(response.Chain) > 0 {
+				ptr = renderChain(response.Chain)
+			} else {
+				ptr = fmt.Sprintf("%s -> %s", ptr, response.Target)
+			}
 		}
 
 		var line string
 		if len(cfg.dnsServers) > 0 {
-			line = fmt.Sprintf("[gray]%s [gray]│[-] [purple]%15s[-] [gray]│[-] [aqua]%-15s[-] [gray]│[-] %-5s [gray]│[-] %s [gray]│[-] %s\n",
+			line = fmt.Sprintf("[gray]%s [gray]│[-] [purple]%s[-] [gray]│[-] [aqua]%-15s[-] [gray]│[-] %-5s [gray]│[-] %s [gray]│[-] %s\n",
 				timeStr,
-				ip,
+				formatIPColumn(ip),
 				response.Server,
 				recordTypeColor,
 				colorizeTTL(response.TTL),
 				colorizeIPInPtr(ptr, ip))
 		} else {
-			line = fmt.Sprintf("[gray]%s [gray]│[-] [purple]%15s[-] [gray]│[-] %-5s [gray]│[-] %s [gray]│[-] %s\n",
+			line = fmt.Sprintf("[gray]%s [gray]│[-] [purple]%s[-] [gray]│[-] %-5s [gray]│[-] %s [gray]│[-] %s\n",
 				timeStr,
-				ip,
+				formatIPColumn(ip),
 				recordTypeColor,
 				colorizeTTL(response.TTL),
 				colorizeIPInPtr(ptr, ip))
@@ -427,6 +704,18 @@ func worker(jobs <-chan string, wg *sync.WaitGroup, cfg *Config, stats *Stats, t
 	}
 }
 
+// cidrList collects repeated -cidr flag occurrences into a slice.
+type cidrList []string
+
+func (c *cidrList) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *cidrList) Set(value string) error {
+	*c = append(*c, value)
+	return nil
+}
+
 func parseShardArg(shard string) (int, int, error) {
 	if shard == "" {
 		return 1, 1, nil
@@ -472,14 +761,23 @@ func (c *Config) updateDNSServers() error {
 	}
 
 	for i, server := range resolvers {
-		if !strings.Contains(server, ":") {
+		if !strings.Contains(server, "://") && !strings.Contains(server, ":") {
 			resolvers[i] = server + ":53"
 		}
 	}
 
+	specs := make(map[string]resolver.Spec, len(resolvers))
+	for _, server := range resolvers {
+		spec, err := resolver.Parse(server)
+		if err != nil {
+			return fmt.Errorf("failed to parse resolver %q: %v", server, err)
+		}
+		specs[server] = spec
+	}
+
 	c.mu.Lock()
 	c.dnsServers = resolvers
-	c.serverIndex = 0
+	c.specs = specs
 	c.lastDNSUpdate = time.Now()
 	c.mu.Unlock()
 
@@ -492,12 +790,27 @@ func main() {
 	retries := flag.Int("r", 2, "Number of retries for failed lookups")
 	dnsFile := flag.String("dns", "", "File containing DNS servers (one per line)")
 	debug := flag.Bool("debug", false, "Show unsuccessful lookups")
-	outputPath := flag.String("o", "", "Path to NDJSON output file")
+	outputPath := flag.String("o", "", "Path to query log file (rotating gzip NDJSON; replayed on startup if present)")
+	logMaxSize := flag.Int64("log-max-size", 100<<20, "Max query log size in bytes before rotation, 0 disables rotation")
+	httpAddr := flag.String("http", "", "Address to serve stats as JSON, e.g. :8080 (resolver health at /health; top-N query stats at / when -o is set)")
+	maxCNAMEDepth := flag.Int("max-cname-depth", defaultMaxCNAMEDepth, "Max CNAME hops to follow looking for a terminal PTR/A/AAAA record")
 	seed := flag.Int64("s", 0, "Seed for IP generation (0 for random)")
 	shard := flag.String("shard", "", "Shard specification (e.g., 1/4 for first shard of 4)")
 	loop := flag.Bool("l", false, "Loop continuously after completion")
+	ipv6Mode := flag.Bool("6", false, "Enable smart IPv6 PTR sweep mode (requires -cidr)")
+	var cidrs cidrList
+	flag.Var(&cidrs, "cidr", "IPv6 CIDR to sweep, e.g. 2001:db8::/32 (repeatable, requires -6)")
 	flag.Parse()
 
+	if *ipv6Mode && len(cidrs) == 0 {
+		fmt.Println("Error: -6 requires at least one -cidr")
+		return
+	}
+	if !*ipv6Mode && len(cidrs) > 0 {
+		fmt.Println("Error: -cidr requires -6")
+		return
+	}
+
 	shardNum, totalShards, err := parseShardArg(*shard)
 	if err != nil {
 		fmt.Printf("Error parsing shard argument: %v\n", err)
@@ -515,29 +828,102 @@ func main() {
 	}
 
 	for i, server := range servers {
-		if !strings.Contains(server, ":") {
+		if !strings.Contains(server, "://") && !strings.Contains(server, ":") {
 			servers[i] = server + ":53"
 		}
 	}
 
+	specList := make([]resolver.Spec, len(servers))
+	for i, server := range servers {
+		spec, err := resolver.Parse(server)
+		if err != nil {
+			fmt.Printf("Error parsing DNS server %q: %v\n", server, err)
+			return
+		}
+		specList[i] = spec
+	}
+
+	var bootstrapAddrs []string
+	for _, spec := range specList {
+		if spec.Scheme == "udp" || spec.Scheme == "tcp" {
+			bootstrapAddrs = append(bootstrapAddrs, spec.Addr)
+		}
+	}
+
+	specList, err = resolver.Bootstrap(specList, bootstrapAddrs, *timeout)
+	if err != nil {
+		fmt.Printf("Error bootstrapping DNS resolvers: %v\n", err)
+		return
+	}
+
+	specs := make(map[string]resolver.Spec, len(servers))
+	for i, server := range servers {
+		specs[server] = specList[i]
+	}
+
 	cfg := &Config{
 		concurrency:   *concurrency,
 		timeout:       *timeout,
 		retries:       *retries,
 		debug:         *debug,
 		dnsServers:    servers,
+		specs:         specs,
+		resolvers:     make(map[string]resolver.Resolver),
+		health:        health.NewTracker(),
+		maxCNAMEDepth: *maxCNAMEDepth,
 		lastDNSUpdate: time.Now(),
 		loop:          *loop,
 	}
 
+	if len(cfg.dnsServers) > 0 {
+		go func() {
+			for {
+				time.Sleep(5 * time.Second)
+				cfg.evictQuarantined()
+			}
+		}()
+	}
+
 	if *outputPath != "" {
-		f, err := os.OpenFile(*outputPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil {
-			fmt.Printf("Error opening output file: %v\n", err)
+		cfg.queryLog = querylog.NewAggregator(queryLogTopCap)
+
+		if n, err := cfg.queryLog.Replay(*outputPath); err != nil {
+			fmt.Printf("Error replaying query log: %v\n", err)
+			return
+		} else if n > 0 {
+			fmt.Printf("Replayed %d entries from query log\n", n)
+		}
+
+		if err := cfg.queryLog.Open(*outputPath, *logMaxSize); err != nil {
+			fmt.Printf("Error opening query log: %v\n", err)
 			return
 		}
-		cfg.outputFile = f
-		defer f.Close()
+		defer cfg.queryLog.Close()
+
+		// A bare gzip.Writer only gets its footer on Close, so without this the query log's
+		// active member is left truncated (though still replayable - see Aggregator.Replay) on
+		// every Ctrl-C or SIGTERM. Closing here writes the footer before the process exits.
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigCh
+			cfg.queryLog.Close()
+			os.Exit(0)
+		}()
+	}
+
+	if *httpAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/health", cfg.health.ServeHTTP)
+		if cfg.queryLog != nil {
+			mux.HandleFunc("/", cfg.queryLog.ServeHTTP)
+		}
+
+		go func() {
+			if err := http.ListenAndServe(*httpAddr, mux); err != nil {
+				fmt.Printf("Error serving stats: %v\n", err)
+			}
+		}()
 	}
 
 	app := tview.NewApplication()
@@ -560,8 +946,66 @@ func main() {
 		AddItem(textView, 0, 1, false).
 		AddItem(progress, 4, 0, false)
 
+	if cfg.queryLog != nil {
+		topView := tview.NewTextView().
+			SetDynamicColors(true).
+			SetChangedFunc(func() {
+				app.Draw()
+			})
+		topView.SetBorder(true).SetTitle(" Top ")
+		flex.AddItem(topView, 6, 0, false)
+
+		go func() {
+			for {
+				snap := cfg.queryLog.Snapshot(5)
+				app.QueueUpdateDraw(func() {
+					topView.Clear()
+					fmt.Fprintf(topView, " [aqua]Domains:[-] %s\n", formatTopEntries(snap.Domains))
+					fmt.Fprintf(topView, " [aqua]TLDs:[-] %s\n", formatTopEntries(snap.TLDs))
+					fmt.Fprintf(topView, " [aqua]CNAME targets:[-] %s\n", formatTopEntries(snap.CNAMEs))
+					fmt.Fprintf(topView, " [aqua]Failing resolvers:[-] %s\n", formatTopEntries(snap.Resolvers))
+				})
+				time.Sleep(2 * time.Second)
+			}
+		}()
+	}
+
+	if len(cfg.dnsServers) > 0 {
+		healthView := tview.NewTextView().
+			SetDynamicColors(true).
+			SetScrollable(true).
+			SetChangedFunc(func() {
+				app.Draw()
+			})
+		healthView.SetBorder(true).SetTitle(" Resolver Health ")
+		flex.AddItem(healthView, 8, 0, false)
+
+		go func() {
+			for {
+				entries := cfg.health.Snapshot()
+				app.QueueUpdateDraw(func() {
+					healthView.Clear()
+					for _, e := range entries {
+						status := "[green]healthy[-]"
+						if e.Quarantined {
+							status = "[red]quarantined[-]"
+						}
+						fmt.Fprintf(healthView, " [purple]%-15s[-] [gray]│[-] %s [gray]│[-] [aqua]success:[-] %5.1f%% [gray]│[-] [aqua]latency:[-] %6.0fms [gray]│[-] [aqua]timeouts:[-] %-4d [aqua]refused:[-] %-4d [aqua]servfail:[-] %-4d\n",
+							e.Server, status, e.SuccessRate*100, e.MedianLatencyMs, e.Timeouts, e.Refused, e.ServFail)
+					}
+				})
+				time.Sleep(2 * time.Second)
+			}
+		}()
+	}
+
+	total := uint64(1) << 32
+	if *ipv6Mode {
+		total = estimateIPv6Candidates(cidrs, defaultIPv6GenOpts)
+	}
+
 	stats := &Stats{
-		total:         1 << 32,
+		total:         total,
 		lastCheckTime: time.Now(),
 		startTime:     time.Now(),
 	}
@@ -650,9 +1094,16 @@ func main() {
 
 	go func() {
 		for {
-			stream, err := golcg.IPStream("0.0.0.0/0", shardNum, totalShards, int(*seed), nil)
+			var stream <-chan string
+			var err error
+			if *ipv6Mode {
+				stream, err = ipv6Stream(cidrs, shardNum, totalShards, *seed, defaultIPv6GenOpts)
+			} else {
+				stream, err = golcg.IPStream("0.0.0.0/0", shardNum, totalShards, int(*seed), nil)
+			}
 			if err != nil {
 				fmt.Printf("Error creating IP stream: %v\n", err)
+				close(jobs)
 				return
 			}
 
@@ -683,6 +1134,18 @@ func main() {
 	}
 }
 
+func formatTopEntries(entries []querylog.Entry) string {
+	if len(entries) == 0 {
+		return "[gray]-[-]"
+	}
+
+	parts := make([]string, len(entries))
+	for i, e := range entries {
+		parts[i] = fmt.Sprintf("[white]%s[-][darkgray](%d)[-]", e.Key, e.Count)
+	}
+	return strings.Join(parts, "  ")
+}
+
 func formatNumber(n uint64) string {
 	s := fmt.Sprint(n)
 	parts := make([]string, 0)
@@ -716,20 +1179,12 @@ func visibleLength(s string) int {
 	return len(noColors)
 }
 
-func writeNDJSON(cfg *Config, timestamp time.Time, ip, server, ptr, recordType, target string, ttl uint32) {
-	if cfg.outputFile == nil {
+func logQuery(cfg *Config, timestamp time.Time, ip, server, ptr, recordType, target string, ttl uint32, chain []querylog.ChainHop) {
+	if cfg.queryLog == nil {
 		return
 	}
 
-	record := struct {
-		Timestamp  string `json:"timestamp"`
-		IPAddr     string `json:"ip_addr"`
-		DNSServer  string `json:"dns_server"`
-		PTRRecord  string `json:"ptr_record"`
-		RecordType string `json:"record_type"`
-		Target     string `json:"target,omitempty"`
-		TTL        uint32 `json:"ttl"`
-	}{
+	if err := cfg.queryLog.Log(querylog.Record{
 		Timestamp:  timestamp.Format(time.RFC3339),
 		IPAddr:     ip,
 		DNSServer:  server,
@@ -737,14 +1192,33 @@ func writeNDJSON(cfg *Config, timestamp time.Time, ip, server, ptr, recordType,
 		RecordType: recordType,
 		Target:     target,
 		TTL:        ttl,
+		Chain:      chain,
+	}); err != nil {
+		fmt.Printf("Error writing query log: %v\n", err)
 	}
+}
 
-	if data, err := json.Marshal(record); err == nil {
-		cfg.mu.Lock()
-		cfg.outputFile.Write(data)
-		cfg.outputFile.Write([]byte("\n"))
-		cfg.mu.Unlock()
+// renderChain compactly formats a CNAME chain as "a -> b -> c -> final" for the TUI.
+func renderChain(chain []querylog.ChainHop) string {
+	parts := make([]string, 0, len(chain)+1)
+	parts = append(parts, chain[0].Name)
+	for _, hop := range chain {
+		parts = append(parts, hop.Target)
 	}
+	return strings.Join(parts, " -> ")
+}
+
+func logQueryFailure(cfg *Config, timestamp time.Time, ip, server string) {
+	if cfg.queryLog == nil {
+		return
+	}
+
+	cfg.queryLog.Log(querylog.Record{
+		Timestamp: timestamp.Format(time.RFC3339),
+		IPAddr:    ip,
+		DNSServer: server,
+		Failed:    true,
+	})
 }
 
 func formatDuration(d time.Duration) string {
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acidvegas/ptrstream/resolver"
+	"github.com/miekg/dns"
+)
+
+// chainFakeResolver answers PTR/CNAME/A/AAAA queries from a fixed name->records table, so
+// followChain can be exercised without a real upstream.
+type chainFakeResolver struct {
+	answers map[string][]dns.RR // keyed by fqdn+" "+qtype
+}
+
+func (f *chainFakeResolver) Exchange(m *dns.Msg) (*dns.Msg, bool, error) {
+	q := m.Question[0]
+	key := q.Name + " " + dns.TypeToString[q.Qtype]
+
+	reply := new(dns.Msg)
+	reply.SetReply(m)
+	reply.Answer = f.answers[key]
+	if len(reply.Answer) == 0 {
+		reply.Rcode = dns.RcodeNameError
+	}
+	return reply, false, nil
+}
+
+// newChainTestConfig returns a Config whose sole resolver "fake" is backed by r, bypassing real
+// network transports entirely.
+func newChainTestConfig(r resolver.Resolver) *Config {
+	return &Config{
+		timeout:   time.Second,
+		specs:     map[string]resolver.Spec{"fake": {}},
+		resolvers: map[string]resolver.Resolver{"fake": r},
+	}
+}
+
+func rr(t *testing.T, s string) dns.RR {
+	t.Helper()
+	r, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("dns.NewRR(%q): %v", s, err)
+	}
+	return r
+}
+
+func TestFollowChainDetectsLoop(t *testing.T) {
+	fake := &chainFakeResolver{answers: map[string][]dns.RR{
+		// a.example.com/b.example.com aren't in-addr.arpa/ip6.arpa names, so followChain queries
+		// A (then AAAA); a real server answers a CNAME-having A query with the CNAME record.
+		"a.example.com. A": {rr(t, "a.example.com. 60 IN CNAME b.example.com.")},
+		"b.example.com. A": {rr(t, "b.example.com. 60 IN CNAME a.example.com.")},
+	}}
+	cfg := newChainTestConfig(fake)
+
+	visited := map[string]bool{"x.in-addr.arpa": true}
+	_, _, _, err := followChain(cfg, "fake", "a.example.com", visited, defaultMaxCNAMEDepth)
+	if err == nil {
+		t.Fatal("expected a loop-detected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "loop detected") {
+		t.Fatalf("expected a loop-detected error, got: %v", err)
+	}
+}
+
+func TestFollowChainExceedsMaxDepth(t *testing.T) {
+	fake := &chainFakeResolver{answers: map[string][]dns.RR{
+		"a0.example.com. A": {rr(t, "a0.example.com. 60 IN CNAME a1.example.com.")},
+		"a1.example.com. A": {rr(t, "a1.example.com. 60 IN CNAME a2.example.com.")},
+		"a2.example.com. A": {rr(t, "a2.example.com. 60 IN CNAME a3.example.com.")},
+		"a3.example.com. A": {rr(t, "a3.example.com. 60 IN CNAME a4.example.com.")},
+	}}
+	cfg := newChainTestConfig(fake)
+
+	visited := map[string]bool{"x.in-addr.arpa": true}
+	_, _, _, err := followChain(cfg, "fake", "a0.example.com", visited, 2)
+	if err == nil {
+		t.Fatal("expected a max-depth error, got nil")
+	}
+	if !strings.Contains(err.Error(), "exceeded max depth") {
+		t.Fatalf("expected a max-depth error, got: %v", err)
+	}
+}
+
+func TestFollowChainResolvesTerminalRecord(t *testing.T) {
+	fake := &chainFakeResolver{answers: map[string][]dns.RR{
+		"1.2.0.192.in-addr.arpa. PTR": {rr(t, "1.2.0.192.in-addr.arpa. 60 IN CNAME host.example.com.")},
+		"host.example.com. A":         {rr(t, "host.example.com. 60 IN A 192.0.2.1")},
+	}}
+	cfg := newChainTestConfig(fake)
+
+	visited := map[string]bool{}
+	chain, final, ttl, err := followChain(cfg, "fake", "1.2.0.192.in-addr.arpa", visited, defaultMaxCNAMEDepth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if final != "192.0.2.1" || ttl != 60 {
+		t.Fatalf("got final=%q ttl=%d, want final=192.0.2.1 ttl=60", final, ttl)
+	}
+	if len(chain) != 2 || chain[0].Type != "CNAME" || chain[1].Type != "A" {
+		t.Fatalf("unexpected chain: %+v", chain)
+	}
+}
@@ -0,0 +1,286 @@
+// Package querylog implements a rotating, gzip-compressed on-disk log of PTR lookup results,
+// paired with in-memory top-N aggregators ("top domains", "top TLDs", "top CNAME targets", and
+// "top failing resolvers") that the TUI and an HTTP endpoint can both read from.
+package querylog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ChainHop is one hop in a CNAME resolution chain followed after a PTR query returned a CNAME
+// instead of a PTR record.
+type ChainHop struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"` // "CNAME", "PTR", "A", or "AAAA"
+	Target string `json:"target"`
+	TTL    uint32 `json:"ttl"`
+}
+
+// Record is a single lookup outcome, either persisted to the on-disk log (successful lookups)
+// or fed to the in-memory aggregators only (failures).
+type Record struct {
+	Timestamp  string     `json:"timestamp"`
+	IPAddr     string     `json:"ip_addr"`
+	DNSServer  string     `json:"dns_server"`
+	PTRRecord  string     `json:"ptr_record,omitempty"`
+	RecordType string     `json:"record_type,omitempty"`
+	Target     string     `json:"target,omitempty"`
+	TTL        uint32     `json:"ttl"`
+	Chain      []ChainHop `json:"chain,omitempty"`
+	Failed     bool       `json:"failed,omitempty"`
+}
+
+// Entry is one ranked item in a top-N snapshot.
+type Entry struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// Snapshot is the point-in-time view of all tracked top-N categories, as served over HTTP and
+// rendered in the TUI's "Top" panel.
+type Snapshot struct {
+	Domains   []Entry `json:"top_domains"`
+	TLDs      []Entry `json:"top_tlds"`
+	CNAMEs    []Entry `json:"top_cname_targets"`
+	Resolvers []Entry `json:"top_failing_resolvers"`
+}
+
+// Aggregator tracks top-N categories from a stream of Records and, optionally, persists
+// successful records to a rotating gzip log on disk.
+type Aggregator struct {
+	domains   *topKCounter
+	tlds      *topKCounter
+	cnames    *topKCounter
+	resolvers *topKCounter
+
+	logMu   sync.Mutex
+	logFile *rotatingGzipWriter
+}
+
+// NewAggregator returns an Aggregator whose categories each retain at most capPerCategory keys.
+func NewAggregator(capPerCategory int) *Aggregator {
+	return &Aggregator{
+		domains:   newTopKCounter(capPerCategory),
+		tlds:      newTopKCounter(capPerCategory),
+		cnames:    newTopKCounter(capPerCategory),
+		resolvers: newTopKCounter(capPerCategory),
+	}
+}
+
+// Open attaches a rotating gzip log at path to the aggregator. Successful records passed to Log
+// are appended to it; the file rotates to path+".1" once it exceeds maxSize bytes (0 disables
+// rotation). If path already exists, callers should call Replay before Open in order to restore
+// prior aggregator state without re-logging those entries.
+func (a *Aggregator) Open(path string, maxSize int64) error {
+	w, err := newRotatingGzipWriter(path, maxSize)
+	if err != nil {
+		return err
+	}
+	a.logFile = w
+	return nil
+}
+
+// Close flushes and closes the on-disk log, if one is open.
+func (a *Aggregator) Close() error {
+	if a.logFile == nil {
+		return nil
+	}
+	return a.logFile.Close()
+}
+
+// Replay reads path and its rotated predecessor (path+".1") back into the aggregators, so that
+// top-N stats survive a restart. It does not re-append anything to the log. Call it before Open.
+//
+// A truncated trailing gzip member - left behind whenever the process stopped without calling
+// Close, since only Close writes the gzip footer - is expected, not an error: every record
+// flushed before the cut is still recovered, and only the dangling partial tail is dropped.
+func (a *Aggregator) Replay(path string) (int, error) {
+	total := 0
+	for _, p := range []string{path + ".1", path} {
+		n, err := a.replayFile(p)
+		total += n
+		if err == nil || os.IsNotExist(err) {
+			continue
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			fmt.Printf("Query log %s was not closed cleanly last run; recovered %d record(s) before the truncated tail\n", p, n)
+			continue
+		}
+		return total, err
+	}
+	return total, nil
+}
+
+func (a *Aggregator) replayFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read query log %s: %v", path, err)
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	count := 0
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		a.observe(rec)
+		count++
+	}
+
+	return count, scanner.Err()
+}
+
+// Log feeds rec into the top-N aggregators and, if a log file is open, appends it as an NDJSON
+// line. Failed lookups are only reflected in the aggregators (top failing resolvers) and are
+// never persisted.
+func (a *Aggregator) Log(rec Record) error {
+	a.observe(rec)
+
+	if a.logFile == nil || rec.Failed {
+		return nil
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal query log record: %v", err)
+	}
+	data = append(data, '\n')
+
+	a.logMu.Lock()
+	_, err = a.logFile.Write(data)
+	a.logMu.Unlock()
+
+	return err
+}
+
+func (a *Aggregator) observe(rec Record) {
+	if rec.Failed {
+		if rec.DNSServer != "" {
+			a.resolvers.Add(rec.DNSServer)
+		}
+		return
+	}
+
+	a.domains.Add(secondLevelDomain(rec.PTRRecord))
+	a.tlds.Add(tldOf(rec.PTRRecord))
+
+	if rec.RecordType == "CNAME" && rec.Target != "" {
+		a.cnames.Add(rec.Target)
+	}
+}
+
+// Snapshot returns the current top-n entries for every category.
+func (a *Aggregator) Snapshot(n int) Snapshot {
+	return Snapshot{
+		Domains:   a.domains.Top(n),
+		TLDs:      a.tlds.Top(n),
+		CNAMEs:    a.cnames.Top(n),
+		Resolvers: a.resolvers.Top(n),
+	}
+}
+
+// ServeHTTP serves the current top-20 snapshot as JSON, for use as an http.Handler (e.g. mounted
+// behind -http :8080).
+func (a *Aggregator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(a.Snapshot(20)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func tldOf(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" {
+		return ""
+	}
+	labels := strings.Split(name, ".")
+	return labels[len(labels)-1]
+}
+
+func secondLevelDomain(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return name
+	}
+	return strings.Join(labels[len(labels)-2:], ".")
+}
+
+// topKCounter is a bounded-cardinality top-N counter: once it reaches its cap, adding a new key
+// evicts the current minimum. This keeps memory flat under high-cardinality streams (e.g. a
+// sweep touching millions of distinct PTR suffixes) at the cost of exactness for keys that
+// hover near the eviction threshold, the same tradeoff a count-min sketch makes.
+type topKCounter struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+	cap    int
+}
+
+func newTopKCounter(cap int) *topKCounter {
+	return &topKCounter{counts: make(map[string]uint64), cap: cap}
+}
+
+func (t *topKCounter) Add(key string) {
+	if key == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.counts[key]; !ok && t.cap > 0 && len(t.counts) >= t.cap {
+		var minKey string
+		var minVal uint64 = ^uint64(0)
+		for k, v := range t.counts {
+			if v < minVal {
+				minKey, minVal = k, v
+			}
+		}
+		delete(t.counts, minKey)
+	}
+
+	t.counts[key]++
+}
+
+func (t *topKCounter) Top(n int) []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := make([]Entry, 0, len(t.counts))
+	for k, v := range t.counts {
+		entries = append(entries, Entry{Key: k, Count: v})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Key < entries[j].Key
+	})
+
+	if n > 0 && n < len(entries) {
+		entries = entries[:n]
+	}
+
+	return entries
+}
@@ -0,0 +1,136 @@
+package querylog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAggregatorOpenLogReplayRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.ndjson.gz")
+
+	a := NewAggregator(10)
+	if err := a.Open(path, 0); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		rec := Record{
+			IPAddr:     fmt.Sprintf("192.0.2.%d", i),
+			DNSServer:  "8.8.8.8:53",
+			PTRRecord:  fmt.Sprintf("host%d.example.com", i),
+			RecordType: "PTR",
+		}
+		if err := a.Log(rec); err != nil {
+			t.Fatalf("Log: %v", err)
+		}
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	replayed := NewAggregator(10)
+	n, err := replayed.Replay(path)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if n != 5 {
+		t.Fatalf("expected 5 replayed records, got %d", n)
+	}
+
+	snap := replayed.Snapshot(10)
+	if len(snap.Domains) != 1 || snap.Domains[0].Key != "example.com" || snap.Domains[0].Count != 5 {
+		t.Fatalf("unexpected domains snapshot: %+v", snap.Domains)
+	}
+}
+
+// TestAggregatorReplayTruncatedTail simulates the common non-graceful-stop case: records were
+// flushed but the gzip footer was never written because Close never ran. Replay should recover
+// every record written before the cut instead of failing outright.
+func TestAggregatorReplayTruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.ndjson.gz")
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(gz, `{"ip_addr":"192.0.2.%d","dns_server":"8.8.8.8:53","ptr_record":"host%d.example.com"}`+"\n", i, i)
+		if err := gz.Flush(); err != nil {
+			t.Fatalf("Flush: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil { // no gz.Close(): leaves the gzip member without a footer
+		t.Fatalf("Close: %v", err)
+	}
+
+	a := NewAggregator(10)
+	n, err := a.Replay(path)
+	if err != nil {
+		t.Fatalf("Replay returned an error for a truncated trailing member: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 recovered records, got %d", n)
+	}
+}
+
+func TestAggregatorReplayMissingFile(t *testing.T) {
+	a := NewAggregator(10)
+	n, err := a.Replay(filepath.Join(t.TempDir(), "does-not-exist.ndjson.gz"))
+	if err != nil {
+		t.Fatalf("Replay of a missing file should not error, got: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("expected 0 records, got %d", n)
+	}
+}
+
+func TestAggregatorOpenRotatesOnSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.ndjson.gz")
+
+	a := NewAggregator(10)
+	if err := a.Open(path, 1); err != nil { // rotate almost immediately
+		t.Fatalf("Open: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := a.Log(Record{IPAddr: "192.0.2.1", DNSServer: "8.8.8.8:53", PTRRecord: "host.example.com"}); err != nil {
+			t.Fatalf("Log: %v", err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("expected a rotated file at %s.1: %v", path, err)
+	}
+}
+
+func TestTopKCounterEvictsMinimumAtCapacity(t *testing.T) {
+	c := newTopKCounter(2)
+	c.Add("a")
+	c.Add("a")
+	c.Add("b")
+	c.Add("c") // over capacity: should evict "b" (count 1), not "a" (count 2)
+
+	top := c.Top(10)
+	keys := make(map[string]uint64, len(top))
+	for _, e := range top {
+		keys[e.Key] = e.Count
+	}
+
+	if _, ok := keys["b"]; ok {
+		t.Fatalf("expected \"b\" to be evicted, got %+v", top)
+	}
+	if keys["a"] != 2 {
+		t.Fatalf("expected \"a\" count 2, got %+v", top)
+	}
+	if _, ok := keys["c"]; !ok {
+		t.Fatalf("expected \"c\" to be present, got %+v", top)
+	}
+}
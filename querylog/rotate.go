@@ -0,0 +1,88 @@
+package querylog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+)
+
+// rotatingGzipWriter appends gzip-compressed lines to path, rotating the file to path+".1" (the
+// previous rotation, if any, is discarded) once its byte count reaches maxSize. The count is the
+// uncompressed bytes written since the last rotation, starting from the on-disk (compressed) size
+// of the file when an existing one is reopened. maxSize <= 0 disables rotation.
+type rotatingGzipWriter struct {
+	path    string
+	maxSize int64
+	written int64
+	file    *os.File
+	gz      *gzip.Writer
+}
+
+func newRotatingGzipWriter(path string, maxSize int64) (*rotatingGzipWriter, error) {
+	w := &rotatingGzipWriter{path: path, maxSize: maxSize}
+	if err := w.openAppend(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingGzipWriter) openAppend() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open query log %s: %v", w.path, err)
+	}
+
+	if info, err := f.Stat(); err == nil {
+		w.written = info.Size()
+	}
+
+	w.file = f
+	w.gz = gzip.NewWriter(f)
+
+	return nil
+}
+
+// Write appends p to the log, rotating first if the size cap has been reached. Each call flushes
+// the gzip stream so that a concurrent reader (e.g. replay on the next startup) sees complete
+// records rather than data sitting in the compressor's internal buffer.
+func (w *rotatingGzipWriter) Write(p []byte) (int, error) {
+	if w.maxSize > 0 && w.written >= w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.gz.Write(p)
+	w.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	return n, w.gz.Flush()
+}
+
+func (w *rotatingGzipWriter) rotate() error {
+	if err := w.gz.Close(); err != nil {
+		return fmt.Errorf("failed to close query log before rotation: %v", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close query log before rotation: %v", err)
+	}
+
+	rotated := w.path + ".1"
+	os.Remove(rotated)
+	if err := os.Rename(w.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate query log: %v", err)
+	}
+
+	w.written = 0
+
+	return w.openAppend()
+}
+
+func (w *rotatingGzipWriter) Close() error {
+	if err := w.gz.Close(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
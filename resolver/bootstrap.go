@@ -0,0 +1,71 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Bootstrap resolves the hostname of every DoT/DoH spec in specs to an IP address, using
+// bootstrapAddrs (plain udp/tcp resolvers, already IP-addressed) to perform the lookup. This
+// mirrors how modern DNS clients handle hostname-based DoT/DoH upstreams: resolve the upstream's
+// own hostname once via a plain resolver, then dial it directly from then on. Specs whose Host
+// is already an IP literal, or whose scheme is udp/tcp, are returned unchanged.
+func Bootstrap(specs []Spec, bootstrapAddrs []string, timeout time.Duration) ([]Spec, error) {
+	out := make([]Spec, len(specs))
+	copy(out, specs)
+
+	for i, spec := range out {
+		if spec.Scheme != "tls" && spec.Scheme != "https" {
+			continue
+		}
+		if net.ParseIP(spec.Host) != nil {
+			continue
+		}
+		if len(bootstrapAddrs) == 0 {
+			return out, fmt.Errorf("resolver %s://%s needs a hostname bootstrap but no plain resolvers are available", spec.Scheme, spec.Host)
+		}
+
+		ip, err := bootstrapLookup(spec.Host, bootstrapAddrs, timeout)
+		if err != nil {
+			return out, fmt.Errorf("failed to bootstrap resolver %s: %v", spec.Host, err)
+		}
+
+		_, port, err := net.SplitHostPort(spec.Addr)
+		if err != nil {
+			return out, fmt.Errorf("failed to bootstrap resolver %s: %v", spec.Host, err)
+		}
+		out[i].Addr = net.JoinHostPort(ip, port)
+	}
+
+	return out, nil
+}
+
+func bootstrapLookup(host string, bootstrapAddrs []string, timeout time.Duration) (string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(host), dns.TypeA)
+	m.RecursionDesired = true
+
+	c := &dns.Client{Timeout: timeout}
+
+	var lastErr error
+	for _, addr := range bootstrapAddrs {
+		r, _, err := c.Exchange(m, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, ans := range r.Answer {
+			if a, ok := ans.(*dns.A); ok {
+				return a.A.String(), nil
+			}
+		}
+
+		lastErr = fmt.Errorf("no A record found for %s via %s", host, addr)
+	}
+
+	return "", fmt.Errorf("all bootstrap resolvers failed: %v", lastErr)
+}
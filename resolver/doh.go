@@ -0,0 +1,75 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dohResolver issues queries as RFC 8484 wire-format POSTs against a DNS-over-HTTPS endpoint.
+// The request URL and TLS verification use spec.Host (the resolver's hostname), while the
+// underlying connection dials spec.Addr directly - which, after Bootstrap, may already be an
+// IP address. This keeps SNI and certificate validation correct even when the hostname itself
+// was resolved out-of-band rather than by the system resolver.
+type dohResolver struct {
+	url    string
+	client *http.Client
+}
+
+func newDoHResolver(spec Spec, timeout time.Duration) *dohResolver {
+	dialAddr := spec.Addr
+
+	return &dohResolver{
+		url: fmt.Sprintf("https://%s/dns-query", spec.Host),
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return (&net.Dialer{Timeout: timeout}).DialContext(ctx, network, dialAddr)
+				},
+			},
+		},
+	}
+}
+
+func (r *dohResolver) Exchange(m *dns.Msg) (*dns.Msg, bool, error) {
+	packed, err := m.Pack()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to pack DoH query: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("DoH query failed with status: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, false, fmt.Errorf("failed to unpack DoH response: %v", err)
+	}
+
+	return reply, reply.Truncated, nil
+}
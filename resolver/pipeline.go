@@ -0,0 +1,239 @@
+package resolver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// maxOutstanding caps how many queries a single pipelinedResolver connection will carry at
+// once, so one fast resolver can't grow its correlation table (or the underlying socket's send
+// queue) without bound.
+const maxOutstanding = 4096
+
+// reapInterval is how often the reaper sweeps the correlation table for queries that have been
+// waiting longer than the resolver's timeout without a reply.
+const reapInterval = 1 * time.Second
+
+type pendingQuery struct {
+	ch     chan *dns.Msg
+	sentAt time.Time
+}
+
+// pipelinedResolver multiplexes many outstanding queries over a single persistent connection to
+// one upstream resolver, correlating replies to requests by DNS message ID instead of opening a
+// new socket per query. A background reader goroutine demuxes incoming replies, and a reaper
+// goroutine times out entries that never get one. This is what lets one fast resolver sustain
+// hundreds of concurrent in-flight queries at the cost of two long-lived goroutines instead of
+// one socket and one goroutine per query.
+type pipelinedResolver struct {
+	addr    string
+	dial    func() (net.Conn, error)
+	timeout time.Duration
+
+	reapTicker *time.Ticker
+	done       chan struct{}
+
+	mu      sync.Mutex
+	conn    *dns.Conn
+	pending map[uint16]*pendingQuery
+	closed  bool
+
+	sem chan struct{}
+}
+
+func newPipelinedResolver(addr string, dial func() (net.Conn, error), timeout time.Duration) *pipelinedResolver {
+	r := &pipelinedResolver{
+		addr:       addr,
+		dial:       dial,
+		timeout:    timeout,
+		pending:    make(map[uint16]*pendingQuery),
+		sem:        make(chan struct{}, maxOutstanding),
+		reapTicker: time.NewTicker(reapInterval),
+		done:       make(chan struct{}),
+	}
+	go r.reap()
+	return r
+}
+
+// ensureConn returns the resolver's persistent connection, dialing a new one (and starting its
+// read loop) if none is currently open.
+func (r *pipelinedResolver) ensureConn() (*dns.Conn, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, fmt.Errorf("resolver %s is closed", r.addr)
+	}
+	if r.conn != nil {
+		return r.conn, nil
+	}
+
+	c, err := r.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &dns.Conn{Conn: c}
+	r.conn = conn
+	go r.readLoop(conn)
+
+	return conn, nil
+}
+
+// readLoop demuxes replies read off conn to whichever Exchange call is waiting on the matching
+// message ID, until the connection breaks, at which point everything still outstanding on it
+// fails so its callers don't hang until their own timeout.
+func (r *pipelinedResolver) readLoop(conn *dns.Conn) {
+	for {
+		msg, err := conn.ReadMsg()
+		if err != nil {
+			r.teardown(conn)
+			return
+		}
+
+		r.mu.Lock()
+		pq, ok := r.pending[msg.Id]
+		if ok {
+			delete(r.pending, msg.Id)
+		}
+		r.mu.Unlock()
+
+		if ok {
+			pq.ch <- msg
+		}
+	}
+}
+
+func (r *pipelinedResolver) teardown(conn *dns.Conn) {
+	r.mu.Lock()
+	if r.conn == conn {
+		r.conn = nil
+	}
+	pending := r.pending
+	r.pending = make(map[uint16]*pendingQuery)
+	r.mu.Unlock()
+
+	for _, pq := range pending {
+		close(pq.ch)
+	}
+	conn.Close()
+}
+
+// reap times out any query that has waited longer than the resolver's timeout without a reply,
+// so a dropped packet can't leak a correlation table entry forever. It exits once Close stops
+// reapTicker and closes done, rather than relying on a future tick that Close has just stopped.
+func (r *pipelinedResolver) reap() {
+	for {
+		select {
+		case <-r.reapTicker.C:
+			r.mu.Lock()
+			now := time.Now()
+			for id, pq := range r.pending {
+				if now.Sub(pq.sentAt) > r.timeout {
+					delete(r.pending, id)
+					close(pq.ch)
+				}
+			}
+			r.mu.Unlock()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *pipelinedResolver) Exchange(m *dns.Msg) (*dns.Msg, bool, error) {
+	select {
+	case r.sem <- struct{}{}:
+	default:
+		return nil, false, fmt.Errorf("resolver %s already has %d outstanding queries", r.addr, maxOutstanding)
+	}
+	defer func() { <-r.sem }()
+
+	conn, err := r.ensureConn()
+	if err != nil {
+		return nil, false, err
+	}
+
+	ch := make(chan *dns.Msg, 1)
+
+	// dns.Id() is just a random uint16 with no collision avoidance, and with many outstanding
+	// queries per connection a collision is far from rare. Reusing an in-flight ID would let its
+	// reply get demuxed to the wrong caller in readLoop, so keep drawing a new one under the same
+	// lock as the insert until we find one that isn't already pending.
+	r.mu.Lock()
+	m.Id = dns.Id()
+	for _, taken := r.pending[m.Id]; taken; _, taken = r.pending[m.Id] {
+		m.Id = dns.Id()
+	}
+	r.pending[m.Id] = &pendingQuery{ch: ch, sentAt: time.Now()}
+	r.mu.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(r.timeout))
+	if err := conn.WriteMsg(m); err != nil {
+		r.mu.Lock()
+		delete(r.pending, m.Id)
+		r.mu.Unlock()
+		return nil, false, err
+	}
+
+	select {
+	case reply, ok := <-ch:
+		if !ok {
+			return nil, false, fmt.Errorf("resolver %s: connection closed while query was in flight", r.addr)
+		}
+		return reply, reply.Truncated, nil
+	case <-time.After(r.timeout):
+		r.mu.Lock()
+		delete(r.pending, m.Id)
+		r.mu.Unlock()
+		return nil, false, fmt.Errorf("resolver %s: timed out waiting for reply", r.addr)
+	}
+}
+
+// Close tears down the resolver's persistent connection and fails any queries still in flight on
+// it. Callers use this for graceful teardown when a resolver is quarantined, so a degraded
+// connection isn't kept alive only to be reused once health scoring has already routed traffic
+// elsewhere.
+func (r *pipelinedResolver) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	conn := r.conn
+	r.conn = nil
+	pending := r.pending
+	r.pending = make(map[uint16]*pendingQuery)
+	r.mu.Unlock()
+
+	r.reapTicker.Stop()
+	close(r.done)
+
+	for _, pq := range pending {
+		close(pq.ch)
+	}
+
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+func dialTLS(addr, serverName string, timeout time.Duration) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: timeout}
+		return tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: serverName})
+	}
+}
+
+func dialPlain(network, addr string, timeout time.Duration) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		return net.DialTimeout(network, addr, timeout)
+	}
+}
@@ -0,0 +1,167 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeUDPResolver answers every A query on a loopback UDP socket with a reply that echoes the
+// question and carries an A record for the queried name, so a caller can check it got the reply
+// for its own query rather than someone else's.
+func fakeUDPResolver(t *testing.T) *net.UDPConn {
+	conn := udpLoopback(t)
+	conn.SetReadBuffer(1 << 20)
+	conn.SetWriteBuffer(1 << 20)
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			req := new(dns.Msg)
+			if err := req.Unpack(buf[:n]); err != nil {
+				continue
+			}
+
+			go func(req *dns.Msg, addr *net.UDPAddr) {
+				reply := new(dns.Msg)
+				reply.SetReply(req)
+				if len(req.Question) > 0 {
+					q := req.Question[0]
+					rr, err := dns.NewRR(fmt.Sprintf("%s 60 IN A 127.0.0.%d", q.Name, len(q.Name)%250+1))
+					if err == nil {
+						reply.Answer = append(reply.Answer, rr)
+					}
+				}
+				packed, err := reply.Pack()
+				if err != nil {
+					return
+				}
+				conn.WriteToUDP(packed, addr)
+			}(req, addr)
+		}
+	}()
+
+	return conn
+}
+
+func TestPipelinedResolverExchangeConcurrentQueriesDontCrossWires(t *testing.T) {
+	server := fakeUDPResolver(t)
+	addr := server.LocalAddr().String()
+
+	dial := func() (net.Conn, error) {
+		c, err := net.DialTimeout("udp", addr, 3*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		if udpConn, ok := c.(*net.UDPConn); ok {
+			udpConn.SetReadBuffer(1 << 20)
+			udpConn.SetWriteBuffer(1 << 20)
+		}
+		return c, nil
+	}
+
+	r := newPipelinedResolver(addr, dial, 3*time.Second)
+	defer r.Close()
+
+	const n = 500
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	mismatches := make([]string, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			name := dns.Fqdn(fmt.Sprintf("host-%d.example.com", i))
+			m := new(dns.Msg)
+			m.SetQuestion(name, dns.TypeA)
+
+			reply, _, err := r.Exchange(m)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if len(reply.Question) == 0 || reply.Question[0].Name != name {
+				mismatches[i] = fmt.Sprintf("sent %q, got reply for %v", name, reply.Question)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("query %d failed: %v", i, err)
+		}
+	}
+	for i, m := range mismatches {
+		if m != "" {
+			t.Fatalf("query %d: %s", i, m)
+		}
+	}
+}
+
+func TestPipelinedResolverCloseFailsOutstandingQueries(t *testing.T) {
+	// A server that never replies, so Close (not a reply) is what resolves the query.
+	conn := udpLoopback(t)
+	addr := conn.LocalAddr().String()
+
+	r := newPipelinedResolver(addr, dialPlain("udp", addr, 5*time.Second), 5*time.Second)
+
+	done := make(chan error, 1)
+	go func() {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn("example.com"), dns.TypeA)
+		_, _, err := r.Exchange(m)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond) // let Exchange register itself as pending
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected Exchange to return an error after Close, got nil")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Exchange did not return promptly after Close")
+	}
+}
+
+// TestPipelinedResolverCloseStopsReapGoroutine guards against reap leaking its ticker/goroutine
+// forever - the failure mode of the time.Tick this used to be built on, which nothing can ever
+// Stop.
+func TestPipelinedResolverCloseStopsReapGoroutine(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	conn := udpLoopback(t)
+	addr := conn.LocalAddr().String()
+	r := newPipelinedResolver(addr, dialPlain("udp", addr, 200*time.Millisecond), 200*time.Millisecond)
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn("example.com"), dns.TypeA)
+	r.Exchange(m) // times out since nothing replies; just forces conn+readLoop to start
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	runtime.GC()
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutines leaked after Close: before=%d after=%d", before, after)
+	}
+}
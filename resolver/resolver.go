@@ -0,0 +1,78 @@
+// Package resolver implements pluggable upstream DNS transports - plain UDP, plain TCP,
+// DNS-over-TLS (DoT), and DNS-over-HTTPS (DoH) - selected by a scheme prefix on the resolver
+// URI (udp://, tcp://, tls://, https://; a bare host:port defaults to udp://).
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Resolver issues a single DNS query against one upstream server.
+type Resolver interface {
+	// Exchange sends m and returns the server's reply. truncated reports whether the reply had
+	// its TC bit set, meaning the caller should retry the same query over TCP.
+	Exchange(m *dns.Msg) (r *dns.Msg, truncated bool, err error)
+}
+
+// Spec is a parsed resolver URI: a transport scheme plus the original hostname (used for TLS
+// SNI and DoH requests) and the address actually dialed, which may be a bootstrapped IP when
+// Host is a hostname. See Bootstrap.
+type Spec struct {
+	Scheme string // "udp", "tcp", "tls", or "https"
+	Host   string // original hostname or IP, without port
+	Addr   string // host:port to dial
+}
+
+// Parse splits a resolver URI of the form scheme://host:port (or a bare host:port, which
+// defaults to udp) into a Spec. A missing port defaults to 53 for udp/tcp/tls and 443 for https.
+func Parse(uri string) (Spec, error) {
+	scheme := "udp"
+	rest := uri
+
+	if idx := strings.Index(uri, "://"); idx != -1 {
+		scheme = uri[:idx]
+		rest = uri[idx+3:]
+	}
+
+	switch scheme {
+	case "udp", "tcp", "tls", "https":
+	default:
+		return Spec{}, fmt.Errorf("unsupported resolver scheme %q", scheme)
+	}
+
+	host, port, err := net.SplitHostPort(rest)
+	if err != nil {
+		host = rest
+		if scheme == "https" {
+			port = "443"
+		} else {
+			port = "53"
+		}
+	}
+
+	return Spec{Scheme: scheme, Host: host, Addr: net.JoinHostPort(host, port)}, nil
+}
+
+// New builds a Resolver for spec, using timeout as the per-query deadline. The udp, tcp, and tls
+// schemes return a pipelinedResolver, which keeps one persistent connection per resolver open
+// and multiplexes every outstanding query over it rather than dialing a new socket per query;
+// https relies on the DoH client's own HTTP keep-alive connection reuse instead.
+func New(spec Spec, timeout time.Duration) (Resolver, error) {
+	switch spec.Scheme {
+	case "udp":
+		return newPipelinedResolver(spec.Addr, dialPlain("udp", spec.Addr, timeout), timeout), nil
+	case "tcp":
+		return newPipelinedResolver(spec.Addr, dialPlain("tcp", spec.Addr, timeout), timeout), nil
+	case "tls":
+		return newPipelinedResolver(spec.Addr, dialTLS(spec.Addr, spec.Host, timeout), timeout), nil
+	case "https":
+		return newDoHResolver(spec, timeout), nil
+	default:
+		return nil, fmt.Errorf("unsupported resolver scheme %q", spec.Scheme)
+	}
+}
@@ -0,0 +1,63 @@
+package resolver
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		uri        string
+		wantScheme string
+		wantHost   string
+		wantAddr   string
+	}{
+		{"8.8.8.8", "udp", "8.8.8.8", "8.8.8.8:53"},
+		{"8.8.8.8:53", "udp", "8.8.8.8", "8.8.8.8:53"},
+		{"udp://1.1.1.1:53", "udp", "1.1.1.1", "1.1.1.1:53"},
+		{"tcp://1.1.1.1:53", "tcp", "1.1.1.1", "1.1.1.1:53"},
+		{"tls://dns.google", "tls", "dns.google", "dns.google:53"},
+		{"tls://dns.google:853", "tls", "dns.google", "dns.google:853"},
+		{"https://dns.google", "https", "dns.google", "dns.google:443"},
+		{"https://dns.google:8443", "https", "dns.google", "dns.google:8443"},
+	}
+
+	for _, c := range cases {
+		spec, err := Parse(c.uri)
+		if err != nil {
+			t.Errorf("Parse(%q): unexpected error: %v", c.uri, err)
+			continue
+		}
+		if spec.Scheme != c.wantScheme || spec.Host != c.wantHost || spec.Addr != c.wantAddr {
+			t.Errorf("Parse(%q) = %+v, want {Scheme:%s Host:%s Addr:%s}", c.uri, spec, c.wantScheme, c.wantHost, c.wantAddr)
+		}
+	}
+}
+
+func TestParseUnsupportedScheme(t *testing.T) {
+	if _, err := Parse("quic://1.1.1.1"); err == nil {
+		t.Fatal("expected an error for an unsupported scheme, got nil")
+	}
+}
+
+func TestParseIPv6Literal(t *testing.T) {
+	spec, err := Parse("udp://[2001:4860:4860::8888]:53")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if spec.Host != "2001:4860:4860::8888" {
+		t.Fatalf("expected host %q, got %q", "2001:4860:4860::8888", spec.Host)
+	}
+}
+
+// udpLoopback starts a UDP listener bound to loopback on an ephemeral port and returns its
+// address; callers use it as a tiny stand-in resolver.
+func udpLoopback(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("failed to bind loopback UDP listener: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}